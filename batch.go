@@ -0,0 +1,146 @@
+package ddbcertstorage
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// batchWriteChunkSize and batchGetChunkSize are DynamoDB's hard
+// per-call limits for BatchWriteItem and BatchGetItem.
+const (
+	batchWriteChunkSize = 25
+	batchGetChunkSize   = 100
+)
+
+// maxBatchRetries bounds the UnprocessedItems/UnprocessedKeys
+// retry loop below; DynamoDB returning unprocessed work this many
+// times in a row indicates sustained throttling rather than the
+// ordinary capacity burst the backoff is meant to absorb.
+const maxBatchRetries = 8
+
+// batchBackoff returns an exponential backoff with full jitter for
+// retry attempt (0-indexed), capped at a few seconds so a stuck
+// retry loop doesn't stall callers for minutes.
+func batchBackoff(attempt int) time.Duration {
+	base := 50 * time.Millisecond
+	max := 5 * time.Second
+	d := base << attempt
+	if d <= 0 || d > max {
+		d = max
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// batchWriteItems writes requests to table in chunks of at most
+// batchWriteChunkSize, retrying UnprocessedItems with exponential
+// backoff and jitter.
+func batchWriteItems(ctx context.Context, ddb DynamoAPI, table string, requests []types.WriteRequest) error {
+	for len(requests) > 0 {
+		n := batchWriteChunkSize
+		if n > len(requests) {
+			n = len(requests)
+		}
+		chunk := requests[:n]
+		requests = requests[n:]
+		for attempt := 0; len(chunk) > 0; attempt++ {
+			output, err := ddb.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]types.WriteRequest{table: chunk},
+			})
+			if err != nil {
+				return err
+			}
+			chunk = output.UnprocessedItems[table]
+			if len(chunk) == 0 {
+				break
+			}
+			if attempt >= maxBatchRetries {
+				return context.DeadlineExceeded
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(batchBackoff(attempt)):
+			}
+		}
+	}
+	return nil
+}
+
+// batchGetItems reads keys from table in chunks of at most
+// batchGetChunkSize, retrying UnprocessedKeys with exponential
+// backoff and jitter, and returns every item DynamoDB had a value
+// for. Missing keys are simply absent from the result. Reads are
+// strongly consistent, matching the other read paths (loadItem,
+// Stat) that Load's chunk/LoadMany reassembly must not lag behind.
+func batchGetItems(ctx context.Context, ddb DynamoAPI, table string, keys []map[string]types.AttributeValue) ([]map[string]types.AttributeValue, error) {
+	var items []map[string]types.AttributeValue
+	for len(keys) > 0 {
+		n := batchGetChunkSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		chunk := keys[:n]
+		keys = keys[n:]
+		for attempt := 0; len(chunk) > 0; attempt++ {
+			output, err := ddb.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: map[string]types.KeysAndAttributes{table: {Keys: chunk, ConsistentRead: aws.Bool(true)}},
+			})
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, output.Responses[table]...)
+			chunk = output.UnprocessedKeys[table].Keys
+			if len(chunk) == 0 {
+				break
+			}
+			if attempt >= maxBatchRetries {
+				return nil, context.DeadlineExceeded
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(batchBackoff(attempt)):
+			}
+		}
+	}
+	return items, nil
+}
+
+// LoadMany retrieves the values at keys in as few round-trips as
+// possible via BatchGetItem, which certmagic callers can use to
+// load a full certificate bundle (cert, key, meta) at once
+// instead of issuing one Load per file. Keys with no stored value
+// are simply absent from the returned map. LoadMany returns an
+// error if a DynamoDB call fails or if any value it found cannot
+// be parsed, reassembled, verified, or decrypted. Values stored
+// under WithKMSKey are transparently decrypted, and values spilled
+// to S3 or split across chunk items are transparently reassembled,
+// same as Load.
+func (s *Storage) LoadMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	ddbKeys := make([]map[string]types.AttributeValue, len(keys))
+	for i, key := range keys {
+		ddbKeys[i] = map[string]types.AttributeValue{"Key": &types.AttributeValueMemberS{Value: key}}
+	}
+	rawItems, err := batchGetItems(ctx, s.ddb, s.table, ddbKeys)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string][]byte, len(rawItems))
+	for _, raw := range rawItems {
+		var item Item
+		if err := item.Load(raw); err != nil {
+			return nil, err
+		}
+		value, err := s.loadValue(ctx, &item)
+		if err != nil {
+			return nil, err
+		}
+		values[item.Key] = value
+	}
+	return values, nil
+}
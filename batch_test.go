@@ -0,0 +1,39 @@
+package ddbcertstorage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatchBackoffBounds(t *testing.T) {
+	for attempt := 0; attempt < 20; attempt++ {
+		for i := 0; i < 50; i++ {
+			d := batchBackoff(attempt)
+			if d < 0 {
+				t.Fatalf("batchBackoff(%d) = %v, want >= 0", attempt, d)
+			}
+			if d > 5*time.Second {
+				t.Fatalf("batchBackoff(%d) = %v, want <= 5s cap", attempt, d)
+			}
+		}
+	}
+}
+
+func TestBatchBackoffStaysUnderAttemptCeiling(t *testing.T) {
+	// batchBackoff applies full jitter in [0, base<<attempt), capped
+	// at 5s, so every draw must stay under whichever of those is
+	// smaller for that attempt.
+	base := 50 * time.Millisecond
+	backoffCap := 5 * time.Second
+	for attempt := 0; attempt < 10; attempt++ {
+		ceiling := base << attempt
+		if ceiling <= 0 || ceiling > backoffCap {
+			ceiling = backoffCap
+		}
+		for i := 0; i < 50; i++ {
+			if d := batchBackoff(attempt); d >= ceiling {
+				t.Fatalf("batchBackoff(%d) = %v, want < %v", attempt, d, ceiling)
+			}
+		}
+	}
+}
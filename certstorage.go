@@ -2,17 +2,19 @@ package ddbcertstorage
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"io/fs"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/caddyserver/certmagic"
+	"github.com/hashicorp/golang-lru/v2/expirable"
 )
 
 // Storage is a type that implements a key-value store with
@@ -50,55 +52,133 @@ import (
 // suitable for very large files.
 type Storage struct {
 	table string
-	ddb   *dynamodb.Client
+	ddb   DynamoAPI
+
+	lockTTL            time.Duration
+	heartbeatInterval  time.Duration
+	enableTTLAttribute bool
+
+	locksMu sync.Mutex
+	locks   map[string]*heldLock
+
+	useLegacyScan bool
+
+	kmsKeyID    string
+	kms         *kms.Client
+	dekCache    *expirable.LRU[string, []byte]
+	dekCacheTTL time.Duration
+
+	chunkThreshold int
+	s3Bucket       string
+	s3Prefix       string
+	s3             *s3.Client
 }
 
 var _ certmagic.Storage = (*Storage)(nil)
 
-func New(table string) (*Storage, error) {
+// defaultLockTTL is how long a lock is held before DynamoDB TTL
+// is allowed to reap it, and defaultHeartbeatInterval is how
+// often a held lock's Expires attribute is refreshed. The
+// heartbeat runs well inside the TTL so a missed tick or two
+// doesn't let another process steal the lock.
+const (
+	defaultLockTTL           = time.Minute
+	defaultHeartbeatInterval = 20 * time.Second
+
+	// defaultDEKCacheTTL and defaultDEKCacheSize bound the cache
+	// of KMS-decrypted data encryption keys, trading a little
+	// staleness for avoiding a KMS Decrypt call on every Load.
+	defaultDEKCacheTTL  = 5 * time.Minute
+	defaultDEKCacheSize = 128
+
+	// defaultChunkThreshold leaves headroom under DynamoDB's
+	// 400 KB item size limit for Key, Modified, and the other
+	// fixed attributes on an item.
+	defaultChunkThreshold = 350 * 1024
+)
+
+func newStorage(table string, ddb DynamoAPI, cfg aws.Config, opts ...Option) *Storage {
+	s := &Storage{
+		table:              table,
+		ddb:                ddb,
+		lockTTL:            defaultLockTTL,
+		heartbeatInterval:  defaultHeartbeatInterval,
+		enableTTLAttribute: true,
+		locks:              make(map[string]*heldLock),
+		dekCacheTTL:        defaultDEKCacheTTL,
+		chunkThreshold:     defaultChunkThreshold,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.kmsKeyID != "" {
+		s.kms = kms.NewFromConfig(cfg)
+		s.dekCache = expirable.NewLRU[string, []byte](defaultDEKCacheSize, nil, s.dekCacheTTL)
+	}
+	if s.s3Bucket != "" {
+		s.s3 = s3.NewFromConfig(cfg)
+	}
+	return s
+}
+
+func New(table string, opts ...Option) (*Storage, error) {
 	cfg, err := config.LoadDefaultConfig(context.Background())
 	if err != nil {
 		return nil, err
 	}
-	return &Storage{
-		table: table,
-		ddb:   dynamodb.NewFromConfig(cfg),
-	}, nil
+	return newStorage(table, dynamodb.NewFromConfig(cfg), cfg, opts...), nil
 }
 
 // Store puts value at key. It creates the key if it does
 // not exist and overwrites any existing value at this key.
+//
+// The key's parent directory components and the terminal item(s)
+// are written together via BatchWriteItem instead of one PutItem
+// per path component. If a KMS key was configured with
+// WithKMSKey, value is envelope-encrypted before it is written.
+// If the (possibly encrypted) value is larger than the configured
+// chunk threshold, it is spilled to S3 (WithS3Overflow) or split
+// across chunk items (see chunking.go) instead of being rejected
+// by DynamoDB's 400 KB item limit.
+//
+// If key already held a chunked or S3-overflowed value, whatever
+// of that overflow the new value doesn't overwrite (e.g. it has
+// fewer chunks, or no longer overflows at all) is best-effort
+// cleaned up via pruneOverflow once the new value is written.
 func (s *Storage) Store(ctx context.Context, key string, value []byte) error {
+	oldItem, err := s.loadItem(ctx, key)
+	if err != nil {
+		return err
+	}
 	keyParts := strings.Split(key, "/")
+	now := time.Now()
+	requests := make([]types.WriteRequest, 0, len(keyParts)+1)
 	for i := 0; i < len(keyParts)-1; i++ {
 		item := &Item{
 			Key:        strings.Join(keyParts[:i+1], "/"),
-			Modified:   time.Now(),
+			Modified:   now,
 			IsTerminal: false,
 		}
-		if _, err := s.ddb.PutItem(ctx, &dynamodb.PutItemInput{
-			TableName: &s.table,
-			Item:      item.Item(),
-		}); err != nil {
-			return err
-		}
+		requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: item.Item()}})
 	}
-	item := &Item{
-		Key:        key,
-		Contents:   value,
-		Modified:   time.Now(),
-		Size:       int64(len(value)),
-		IsTerminal: true,
+	newItem, valueRequests, err := s.storeValue(ctx, key, value, now)
+	if err != nil {
+		return err
 	}
-	_, err := s.ddb.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: &s.table,
-		Item:      item.Item(),
-	})
-	return err
+	requests = append(requests, valueRequests...)
+	if err := batchWriteItems(ctx, s.ddb, s.table, requests); err != nil {
+		return err
+	}
+	if oldItem != nil && oldItem.IsTerminal && (oldItem.ChunkCount > 0 || oldItem.S3Key != "") {
+		s.pruneOverflow(ctx, oldItem, newItem)
+	}
+	return nil
 }
 
-// Load retrieves the value at key.
-func (s *Storage) Load(ctx context.Context, key string) ([]byte, error) {
+// loadItem fetches and parses the raw item at key, returning nil
+// (not fs.ErrNotExist) if it doesn't exist, for callers like Store
+// that only need to know what, if anything, they're overwriting.
+func (s *Storage) loadItem(ctx context.Context, key string) (*Item, error) {
 	output, err := s.ddb.GetItem(ctx, &dynamodb.GetItemInput{
 		TableName:      &s.table,
 		ConsistentRead: aws.Bool(true),
@@ -110,13 +190,28 @@ func (s *Storage) Load(ctx context.Context, key string) ([]byte, error) {
 		return nil, err
 	}
 	if len(output.Item) == 0 {
-		return nil, fs.ErrNotExist
+		return nil, nil
 	}
 	var item Item
 	if err := item.Load(output.Item); err != nil {
 		return nil, err
 	}
-	return item.Contents, nil
+	return &item, nil
+}
+
+// Load retrieves the value at key. If the value was stored under
+// WithKMSKey it is transparently decrypted, and if it was spilled
+// to S3 or split across chunk items (see chunking.go) it is
+// transparently reassembled.
+func (s *Storage) Load(ctx context.Context, key string) ([]byte, error) {
+	item, err := s.loadItem(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, fs.ErrNotExist
+	}
+	return s.loadValue(ctx, item)
 }
 
 // Delete deletes the named key. If the name is a
@@ -143,28 +238,13 @@ func (s *Storage) Delete(ctx context.Context, key string) error {
 		return err
 	}
 	if !item.IsTerminal {
-		output, err := s.ddb.Scan(ctx, &dynamodb.ScanInput{
-			TableName:                 &s.table,
-			ExpressionAttributeNames:  map[string]string{"#key": "Key"},
-			ExpressionAttributeValues: map[string]types.AttributeValue{":key": &types.AttributeValueMemberS{Value: key + "/"}},
-			FilterExpression:          aws.String("begins_with(#key, :key)"),
-		})
-		if err != nil {
-			return err
-		}
-		if len(output.Items) == 0 {
-			return fs.ErrNotExist
-		}
-		for _, i := range output.Items {
-			if _, err := s.ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
-				TableName: &s.table,
-				Key: map[string]types.AttributeValue{
-					"Key": i["Key"],
-				},
-			}); err != nil {
-				return err
-			}
+		if s.useLegacyScan {
+			return s.deleteChildrenScan(ctx, key)
 		}
+		return s.deleteChildrenIndexed(ctx, key)
+	}
+	if item.ChunkCount > 0 || item.S3Key != "" {
+		s.deleteOverflow(ctx, &item)
 	}
 	return nil
 }
@@ -172,10 +252,13 @@ func (s *Storage) Delete(ctx context.Context, key string) error {
 // Exists returns true if the key exists either as
 // a directory (prefix to other keys) or a file,
 // and there was no error checking.
+//
+// Exists reads with eventual consistency so that a
+// DAX-backed Storage (see NewWithDAX) can serve it
+// from cache instead of going to DynamoDB.
 func (s *Storage) Exists(ctx context.Context, key string) bool {
 	output, err := s.ddb.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName:      &s.table,
-		ConsistentRead: aws.Bool(true),
+		TableName: &s.table,
 		Key: map[string]types.AttributeValue{
 			"Key": &types.AttributeValueMemberS{Value: key},
 		},
@@ -189,40 +272,15 @@ func (s *Storage) Exists(ctx context.Context, key string) bool {
 // will be enumerated (i.e. "directories"
 // should be walked); otherwise, only keys
 // prefixed exactly by prefix will be listed.
+//
+// List reads with eventual consistency so that a
+// DAX-backed Storage (see NewWithDAX) can serve it
+// from cache instead of going to DynamoDB.
 func (s *Storage) List(ctx context.Context, path string, recursive bool) ([]string, error) {
-	output, err := s.ddb.Scan(ctx, &dynamodb.ScanInput{
-		TableName:                 &s.table,
-		ExpressionAttributeNames:  map[string]string{"#key": "Key"},
-		ExpressionAttributeValues: map[string]types.AttributeValue{":key": &types.AttributeValueMemberS{Value: path + "/"}},
-		FilterExpression:          aws.String("begins_with(#key, :key)"),
-	})
-	if err != nil {
-		return nil, err
-	}
-	if len(output.Items) == 0 {
-		return nil, fs.ErrNotExist
+	if s.useLegacyScan {
+		return s.listScan(ctx, path, recursive)
 	}
-	keys := make([]string, 0, len(output.Items))
-	for _, i := range output.Items {
-		var item Item
-		if err := item.Load(i); err != nil {
-			return nil, err
-		}
-		if !recursive {
-			// these two paths go through foo:
-			// foo/cert/key
-			// foo/cert/chain
-			//
-			// So List(prefix: "foo", recursive: false) would return:
-			// foo/cert
-			name := strings.TrimPrefix(item.Key, path+"/")
-			if strings.Contains(name, "/") {
-				continue
-			}
-		}
-		keys = append(keys, item.Key)
-	}
-	return keys, nil
+	return s.listIndexed(ctx, path, recursive)
 }
 
 // Stat returns information about key.
@@ -252,71 +310,5 @@ func (s *Storage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, erro
 	}, nil
 }
 
-// Lock acquires the lock for name, blocking until the lock
-// can be obtained or an error is returned. Only one lock
-// for the given name can exist at a time. A call to Lock for
-// a name which already exists blocks until the named lock
-// is released or becomes stale.
-//
-// If the named lock represents an idempotent operation, callers
-// should always check to make sure the work still needs to be
-// completed after acquiring the lock. You never know if another
-// process already completed the task while you were waiting to
-// acquire it.
-//
-// Implementations should honor context cancellation.
-func (s *Storage) Lock(ctx context.Context, name string) error {
-	for {
-		if _, err := s.ddb.PutItem(ctx, &dynamodb.PutItemInput{
-			TableName: &s.table,
-			Item: map[string]types.AttributeValue{
-				"Key":    &types.AttributeValueMemberS{Value: fmt.Sprintf("LOCK-%s", name)},
-				"Locked": &types.AttributeValueMemberS{Value: time.Now().Format(time.RFC3339)},
-			},
-			ExpressionAttributeNames: map[string]string{"#key": "Key"},
-			ConditionExpression:      aws.String("attribute_not_exists(#key)"),
-		}); err != nil {
-			var condCheckErr *types.ConditionalCheckFailedException
-			if errors.As(err, &condCheckErr) {
-				if str, ok := condCheckErr.Item["Locked"].(*types.AttributeValueMemberS); ok {
-					locked, err := time.Parse(time.RFC3339, str.Value)
-					if err != nil {
-						return err
-					}
-					if time.Since(locked) > time.Minute {
-						if err := s.deleteLock(ctx, name); err != nil {
-							return err
-						}
-						continue
-					}
-				} else {
-					return errors.New("invalid Locked attribute")
-				}
-				time.Sleep(time.Second)
-				continue
-			}
-			return err
-		} else {
-			return nil
-		}
-	}
-}
-
-// Unlock releases named lock. This method must ONLY be called
-// after a successful call to Lock, and only after the critical
-// section is finished, even if it errored or timed out. Unlock
-// cleans up any resources allocated during Lock. Unlock should
-// only return an error if the lock was unable to be released.
-func (s *Storage) Unlock(ctx context.Context, name string) error {
-	return s.deleteLock(ctx, name)
-}
-
-func (s *Storage) deleteLock(ctx context.Context, name string) error {
-	_, err := s.ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
-		TableName: &s.table,
-		Key: map[string]types.AttributeValue{
-			"Key": &types.AttributeValueMemberS{Value: fmt.Sprintf("LOCK-%s", name)},
-		},
-	})
-	return err
-}
+// Lock, Unlock, and the lock's TTL-refreshing heartbeat live in
+// ttllock.go.
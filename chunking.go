@@ -0,0 +1,299 @@
+package ddbcertstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// chunkKeyPrefix separates a manifest item's Key from its chunk
+// sibling items' suffix, e.g. "example.com/cert#chunk/0000".
+const chunkKeyPrefix = "#chunk/"
+
+// WithChunkThreshold overrides the (possibly encrypted) value
+// size, in bytes, above which Store spills a value to S3
+// (WithS3Overflow) or splits it across chunk items instead of
+// writing it as a single DynamoDB item. The default is ~350 KB,
+// which leaves room under DynamoDB's 400 KB item limit for Key,
+// Modified, and the other fixed attributes on an item.
+func WithChunkThreshold(threshold int) Option {
+	return func(s *Storage) {
+		s.chunkThreshold = threshold
+	}
+}
+
+// WithS3Overflow makes Store spill values larger than the chunk
+// threshold to the given S3 bucket (under prefix) instead of
+// splitting them across DynamoDB chunk items, storing only an S3
+// key and ETag alongside the manifest item.
+func WithS3Overflow(bucket, prefix string) Option {
+	return func(s *Storage) {
+		s.s3Bucket = bucket
+		s.s3Prefix = prefix
+	}
+}
+
+// chunkKey returns the key of chunk i of a value stored under key.
+func chunkKey(key string, i int) string {
+	return fmt.Sprintf("%s%s%04d", key, chunkKeyPrefix, i)
+}
+
+// chunkIndex recovers the chunk index from one of key's chunk
+// item keys, as produced by chunkKey.
+func chunkIndex(key, chunk string) (int, error) {
+	suffix := strings.TrimPrefix(chunk, key+chunkKeyPrefix)
+	if suffix == chunk {
+		return 0, fmt.Errorf("ddbcertstorage: %q is not a chunk of %q", chunk, key)
+	}
+	return strconv.Atoi(suffix)
+}
+
+// storeValue builds the PutRequests that store value at key: a
+// single item if it (once KMS-encrypted, if configured) fits
+// under the chunk threshold, or a manifest item plus either an S3
+// pointer or a run of chunk items otherwise. It also returns the
+// manifest item itself, so callers can tell what overflow (if any)
+// the new value left behind, e.g. to clean up a previous value's
+// now-orphaned chunks or S3 object.
+func (s *Storage) storeValue(ctx context.Context, key string, value []byte, now time.Time) (*Item, []types.WriteRequest, error) {
+	contents := value
+	item := &Item{
+		Key:        key,
+		Modified:   now,
+		Size:       int64(len(value)),
+		IsTerminal: true,
+	}
+	if s.kmsKeyID != "" {
+		ciphertext, encryptedDEK, nonce, err := s.encrypt(ctx, value)
+		if err != nil {
+			return nil, nil, err
+		}
+		contents = ciphertext
+		item.EncryptedDEK = encryptedDEK
+		item.Nonce = nonce
+		item.KMSKeyID = s.kmsKeyID
+	}
+	if len(contents) <= s.chunkThreshold {
+		item.Contents = contents
+		return item, []types.WriteRequest{{PutRequest: &types.PutRequest{Item: item.Item()}}}, nil
+	}
+	hash := sha256.Sum256(contents)
+	item.Hash = hash[:]
+	if s.s3Bucket != "" {
+		s3Key, etag, err := s.putS3(ctx, key, contents)
+		if err != nil {
+			return nil, nil, err
+		}
+		item.S3Key = s3Key
+		item.S3ETag = etag
+		return item, []types.WriteRequest{{PutRequest: &types.PutRequest{Item: item.Item()}}}, nil
+	}
+	chunks := splitChunks(contents, s.chunkThreshold)
+	item.ChunkCount = len(chunks)
+	requests := make([]types.WriteRequest, 0, len(chunks)+1)
+	requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: item.Item()}})
+	for i, chunk := range chunks {
+		chunkItem := &Item{
+			Key:        chunkKey(key, i),
+			Contents:   chunk,
+			Modified:   now,
+			Size:       int64(len(chunk)),
+			IsTerminal: true,
+		}
+		requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: chunkItem.Item()}})
+	}
+	return item, requests, nil
+}
+
+// splitChunks splits data into contiguous pieces of at most size
+// bytes each.
+func splitChunks(data []byte, size int) [][]byte {
+	chunks := make([][]byte, 0, (len(data)+size-1)/size)
+	for len(data) > 0 {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+// loadValue reassembles the value behind item: a chunked payload
+// via BatchGetItem, an S3-overflowed payload via GetObject, or
+// just item.Contents for an ordinary item. It verifies item.Hash
+// when present and decrypts when item.KMSKeyID is set.
+func (s *Storage) loadValue(ctx context.Context, item *Item) ([]byte, error) {
+	contents := item.Contents
+	switch {
+	case item.S3Key != "":
+		data, err := s.getS3(ctx, item.S3Key, item.S3ETag)
+		if err != nil {
+			return nil, err
+		}
+		contents = data
+	case item.ChunkCount > 0:
+		data, err := s.getChunks(ctx, item.Key, item.ChunkCount)
+		if err != nil {
+			return nil, err
+		}
+		contents = data
+	}
+	if len(item.Hash) > 0 {
+		sum := sha256.Sum256(contents)
+		if !bytes.Equal(sum[:], item.Hash) {
+			return nil, fmt.Errorf("ddbcertstorage: checksum mismatch reassembling %q", item.Key)
+		}
+	}
+	if item.KMSKeyID == "" {
+		if len(item.EncryptedDEK) > 0 || len(item.Nonce) > 0 {
+			return nil, fmt.Errorf("ddbcertstorage: item %q has DEK/nonce metadata but no KMS key ID", item.Key)
+		}
+		return contents, nil
+	}
+	return s.decrypt(ctx, &Item{
+		Key:          item.Key,
+		Contents:     contents,
+		EncryptedDEK: item.EncryptedDEK,
+		Nonce:        item.Nonce,
+		KMSKeyID:     item.KMSKeyID,
+	})
+}
+
+// getChunks fetches and reassembles all count chunks of key via
+// BatchGetItem, which does not preserve request order, so chunks
+// are placed by the index encoded in their own key.
+func (s *Storage) getChunks(ctx context.Context, key string, count int) ([]byte, error) {
+	keys := make([]map[string]types.AttributeValue, count)
+	for i := 0; i < count; i++ {
+		keys[i] = map[string]types.AttributeValue{"Key": &types.AttributeValueMemberS{Value: chunkKey(key, i)}}
+	}
+	rawItems, err := batchGetItems(ctx, s.ddb, s.table, keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(rawItems) != count {
+		return nil, fmt.Errorf("ddbcertstorage: expected %d chunks for %q, found %d", count, key, len(rawItems))
+	}
+	chunks := make([][]byte, count)
+	total := 0
+	for _, raw := range rawItems {
+		var chunk Item
+		if err := chunk.Load(raw); err != nil {
+			return nil, err
+		}
+		i, err := chunkIndex(key, chunk.Key)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= count {
+			return nil, fmt.Errorf("ddbcertstorage: chunk index %d out of range for %q", i, key)
+		}
+		chunks[i] = chunk.Contents
+		total += len(chunk.Contents)
+	}
+	out := make([]byte, 0, total)
+	for _, chunk := range chunks {
+		out = append(out, chunk...)
+	}
+	return out, nil
+}
+
+// putS3 uploads data for key to the configured overflow bucket,
+// returning the object key and ETag to record on the manifest
+// item.
+func (s *Storage) putS3(ctx context.Context, key string, data []byte) (s3Key, etag string, err error) {
+	s3Key = path.Join(s.s3Prefix, key)
+	out, err := s.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: &s.s3Bucket,
+		Key:    &s3Key,
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return s3Key, aws.ToString(out.ETag), nil
+}
+
+// getS3 downloads the object at s3Key, failing closed if its
+// ETag no longer matches what the manifest item recorded.
+func (s *Storage) getS3(ctx context.Context, s3Key, etag string) ([]byte, error) {
+	if s.s3 == nil {
+		return nil, fmt.Errorf("ddbcertstorage: %q is S3-overflowed but Storage was not configured with WithS3Overflow", s3Key)
+	}
+	out, err := s.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.s3Bucket,
+		Key:    &s3Key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	if etag != "" && aws.ToString(out.ETag) != etag {
+		return nil, fmt.Errorf("ddbcertstorage: S3 object %q ETag changed since it was stored", s3Key)
+	}
+	return io.ReadAll(out.Body)
+}
+
+// deleteOverflow best-effort cleans up the chunk items or S3
+// object behind a deleted manifest item. Failures are logged as
+// orphans rather than returned, since the manifest row (the only
+// thing Load or List can see) is already gone.
+func (s *Storage) deleteOverflow(ctx context.Context, item *Item) {
+	if item.S3Key != "" {
+		if _, err := s.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: &s.s3Bucket,
+			Key:    &item.S3Key,
+		}); err != nil {
+			log.Printf("ddbcertstorage: orphaned S3 object %q in bucket %q after deleting %q: %v", item.S3Key, s.s3Bucket, item.Key, err)
+		}
+		return
+	}
+	requests := make([]types.WriteRequest, item.ChunkCount)
+	for i := range requests {
+		requests[i] = deleteRequest(chunkKey(item.Key, i))
+	}
+	if err := batchWriteItems(ctx, s.ddb, s.table, requests); err != nil {
+		log.Printf("ddbcertstorage: orphaned chunk(s) of %q after delete: %v", item.Key, err)
+	}
+}
+
+// pruneOverflow best-effort cleans up whatever overflow the
+// previous value at old.Key left behind that updated no longer
+// covers: an S3 object updated didn't overwrite because it's no
+// longer S3-overflowed, and any chunk items beyond
+// updated.ChunkCount that updated's (possibly shorter, possibly
+// absent) run of chunks didn't overwrite. Like deleteOverflow,
+// failures are only logged, since Store has already succeeded by
+// the time this runs.
+func (s *Storage) pruneOverflow(ctx context.Context, old, updated *Item) {
+	if old.S3Key != "" && updated.S3Key == "" {
+		if _, err := s.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: &s.s3Bucket,
+			Key:    &old.S3Key,
+		}); err != nil {
+			log.Printf("ddbcertstorage: orphaned S3 object %q in bucket %q after overwriting %q: %v", old.S3Key, s.s3Bucket, old.Key, err)
+		}
+	}
+	if old.ChunkCount > updated.ChunkCount {
+		requests := make([]types.WriteRequest, 0, old.ChunkCount-updated.ChunkCount)
+		for i := updated.ChunkCount; i < old.ChunkCount; i++ {
+			requests = append(requests, deleteRequest(chunkKey(old.Key, i)))
+		}
+		if err := batchWriteItems(ctx, s.ddb, s.table, requests); err != nil {
+			log.Printf("ddbcertstorage: orphaned chunk(s) of %q after overwrite: %v", old.Key, err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package ddbcertstorage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitChunksRoundTrip(t *testing.T) {
+	data := make([]byte, 10*1024+1)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	chunks := splitChunks(data, 4*1024)
+	if len(chunks) != 3 {
+		t.Fatalf("splitChunks produced %d chunks, want 3", len(chunks))
+	}
+	var got []byte
+	for _, c := range chunks {
+		got = append(got, c...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("reassembled chunks do not match the original data")
+	}
+}
+
+func TestSplitChunksEmpty(t *testing.T) {
+	if chunks := splitChunks(nil, 1024); len(chunks) != 0 {
+		t.Fatalf("splitChunks(nil) = %d chunks, want 0", len(chunks))
+	}
+}
+
+func TestChunkKeyIndexRoundTrip(t *testing.T) {
+	key := "example.com/cert"
+	for i := 0; i < 3; i++ {
+		chunk := chunkKey(key, i)
+		got, err := chunkIndex(key, chunk)
+		if err != nil {
+			t.Fatalf("chunkIndex(%q, %q): %v", key, chunk, err)
+		}
+		if got != i {
+			t.Fatalf("chunkIndex(%q, %q) = %d, want %d", key, chunk, got, i)
+		}
+	}
+}
+
+func TestChunkIndexRejectsUnrelatedKey(t *testing.T) {
+	if _, err := chunkIndex("example.com/cert", "example.com/other#chunk/0000"); err == nil {
+		t.Fatal("chunkIndex should reject a chunk key that doesn't belong to the given key")
+	}
+}
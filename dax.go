@@ -0,0 +1,45 @@
+package ddbcertstorage
+
+import (
+	"context"
+
+	"github.com/aws/aws-dax-go-v2/dax"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// DynamoAPI is the subset of *dynamodb.Client that Storage depends
+// on. It is satisfied by *dynamodb.Client itself as well as by a
+// github.com/aws/aws-dax-go-v2 *dax.Dax client, which lets a DAX
+// cluster transparently front GetItem/PutItem/DeleteItem/Scan/Query
+// calls for callers running inside the DAX cluster's VPC.
+type DynamoAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+}
+
+// NewWithDAX is like New, but routes calls through an in-cluster
+// DAX cluster reachable at daxEndpoint instead of talking to
+// DynamoDB directly. Because DAX serves GetItem/Scan from its
+// cache by default, Storage always sets ConsistentRead on reads
+// where staleness would be incorrect (lock acquisition and cert
+// loads), which DAX honors by bypassing its cache and going
+// straight to DynamoDB. List, Exists, and the lookup of a
+// directory's descendants in a recursive Delete do not set
+// ConsistentRead, so through DAX they can be served from the cache.
+func NewWithDAX(table, daxEndpoint string, opts ...Option) (*Storage, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	daxClient, err := dax.NewFromConfig(cfg, daxEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return newStorage(table, daxClient, cfg, opts...), nil
+}
@@ -0,0 +1,118 @@
+package ddbcertstorage
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeDDB is a minimal in-memory DynamoAPI good enough to exercise
+// the conditional-write logic in ttllock.go without a real
+// DynamoDB table. It only understands the handful of condition
+// expressions this package actually builds.
+type fakeDDB struct {
+	mu    sync.Mutex
+	items map[string]map[string]types.AttributeValue
+}
+
+func newFakeDDB() *fakeDDB {
+	return &fakeDDB{items: make(map[string]map[string]types.AttributeValue)}
+}
+
+func cloneAttrs(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	clone := make(map[string]types.AttributeValue, len(item))
+	for k, v := range item {
+		clone[k] = v
+	}
+	return clone
+}
+
+func (f *fakeDDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := params.Key["Key"].(*types.AttributeValueMemberS).Value
+	out := &dynamodb.GetItemOutput{}
+	if item, ok := f.items[key]; ok {
+		out.Item = cloneAttrs(item)
+	}
+	return out, nil
+}
+
+// conditionHolds evaluates the handful of condition expressions
+// ttllock.go issues, given the item currently stored under key (nil
+// if none) and the expression's attribute values.
+func conditionHolds(expr string, existing map[string]types.AttributeValue, values map[string]types.AttributeValue) bool {
+	switch expr {
+	case "attribute_not_exists(#key) OR #expires < :now":
+		if existing == nil {
+			return true
+		}
+		expires, ok := existing["Expires"].(*types.AttributeValueMemberN)
+		if !ok {
+			return false
+		}
+		now := values[":now"].(*types.AttributeValueMemberN)
+		expiresN, _ := strconv.ParseInt(expires.Value, 10, 64)
+		nowN, _ := strconv.ParseInt(now.Value, 10, 64)
+		return expiresN < nowN
+	case "#token = :token":
+		if existing == nil {
+			return false
+		}
+		token, ok := existing["Token"].(*types.AttributeValueMemberS)
+		if !ok {
+			return false
+		}
+		want := values[":token"].(*types.AttributeValueMemberS)
+		return token.Value == want.Value
+	default:
+		return true
+	}
+}
+
+func (f *fakeDDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := params.Item["Key"].(*types.AttributeValueMemberS).Value
+	if params.ConditionExpression != nil {
+		if !conditionHolds(*params.ConditionExpression, f.items[key], params.ExpressionAttributeValues) {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	}
+	f.items[key] = cloneAttrs(params.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := params.Key["Key"].(*types.AttributeValueMemberS).Value
+	if params.ConditionExpression != nil {
+		if !conditionHolds(*params.ConditionExpression, f.items[key], params.ExpressionAttributeValues) {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	}
+	delete(f.items, key)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (f *fakeDDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return &dynamodb.ScanOutput{}, nil
+}
+
+func (f *fakeDDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{}, nil
+}
+
+func (f *fakeDDB) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+func (f *fakeDDB) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	return &dynamodb.BatchGetItemOutput{}, nil
+}
+
+var _ DynamoAPI = (*fakeDDB)(nil)
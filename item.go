@@ -3,6 +3,7 @@ package ddbcertstorage
 import (
 	"errors"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
@@ -14,6 +15,43 @@ type Item struct {
 	Modified   time.Time
 	Size       int64
 	IsTerminal bool
+
+	// Parent is Key with its last '/'-separated component
+	// stripped (the empty string for a top-level key). It backs
+	// the ParentIndex GSI that List and Delete query instead of
+	// scanning the full table; see MigrateSchema.
+	Parent string
+
+	// EncryptedDEK, Nonce, and KMSKeyID are set when Contents
+	// holds AES-256-GCM ciphertext rather than a plaintext value.
+	// EncryptedDEK is the data key KMS wrapped for KMSKeyID, and
+	// Nonce is the GCM nonce used to seal Contents. All three are
+	// empty for a plaintext item. See kms.go.
+	EncryptedDEK []byte
+	Nonce        []byte
+	KMSKeyID     string
+
+	// ChunkCount and Hash are set instead of Contents when an
+	// item is a manifest for a value too large for one DynamoDB
+	// item: Hash is the SHA-256 of the reassembled (but still
+	// possibly encrypted) payload, split across ChunkCount sibling
+	// items at "<Key>#chunk/0000", "<Key>#chunk/0001", and so on.
+	// S3Key and S3ETag are set instead of ChunkCount, alongside
+	// Hash on the same manifest item, when the payload was spilled
+	// to S3 rather than chunked. See
+	// chunking.go.
+	ChunkCount int
+	Hash       []byte
+	S3Key      string
+	S3ETag     string
+}
+
+// parentOf returns the Parent attribute value for key.
+func parentOf(key string) string {
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		return key[:i]
+	}
+	return ""
 }
 
 func (i *Item) Item() map[string]types.AttributeValue {
@@ -31,6 +69,28 @@ func (i *Item) Item() map[string]types.AttributeValue {
 	if i.IsTerminal {
 		item["IsTerminal"] = &types.AttributeValueMemberBOOL{Value: i.IsTerminal}
 	}
+	item["Parent"] = &types.AttributeValueMemberS{Value: parentOf(i.Key)}
+	if len(i.EncryptedDEK) > 0 {
+		item["EncryptedDEK"] = &types.AttributeValueMemberB{Value: i.EncryptedDEK}
+	}
+	if len(i.Nonce) > 0 {
+		item["Nonce"] = &types.AttributeValueMemberB{Value: i.Nonce}
+	}
+	if i.KMSKeyID != "" {
+		item["KMSKeyID"] = &types.AttributeValueMemberS{Value: i.KMSKeyID}
+	}
+	if i.ChunkCount > 0 {
+		item["ChunkCount"] = &types.AttributeValueMemberN{Value: strconv.Itoa(i.ChunkCount)}
+	}
+	if len(i.Hash) > 0 {
+		item["Hash"] = &types.AttributeValueMemberB{Value: i.Hash}
+	}
+	if i.S3Key != "" {
+		item["S3Key"] = &types.AttributeValueMemberS{Value: i.S3Key}
+	}
+	if i.S3ETag != "" {
+		item["S3ETag"] = &types.AttributeValueMemberS{Value: i.S3ETag}
+	}
 	return item
 }
 
@@ -40,10 +100,10 @@ func (i *Item) Load(item map[string]types.AttributeValue) error {
 	} else {
 		return errors.New("invalid attribute value")
 	}
+	// Contents is absent for directory items and for manifest
+	// items backing a chunked or S3-overflowed value.
 	if m, ok := item["Contents"].(*types.AttributeValueMemberB); ok {
 		i.Contents = m.Value
-	} else {
-		return errors.New("invalid attribute value")
 	}
 	if m, ok := item["Modified"].(*types.AttributeValueMemberS); ok {
 		var err error
@@ -54,19 +114,56 @@ func (i *Item) Load(item map[string]types.AttributeValue) error {
 	} else {
 		return errors.New("invalid attribute value")
 	}
+	// Size is absent for directory items, which have no Contents.
 	if m, ok := item["Size"].(*types.AttributeValueMemberN); ok {
 		var err error
 		i.Size, err = strconv.ParseInt(m.Value, 10, 64)
 		if err != nil {
 			return err
 		}
-	} else {
-		return errors.New("invalid attribute value")
 	}
+	// IsTerminal is absent (meaning false) for directory items,
+	// which are only ever written with IsTerminal true omitted.
 	if m, ok := item["IsTerminal"].(*types.AttributeValueMemberBOOL); ok {
 		i.IsTerminal = m.Value
+	}
+	// Parent is tolerated as absent so that items written before
+	// MigrateSchema backfilled it still load.
+	if m, ok := item["Parent"].(*types.AttributeValueMemberS); ok {
+		i.Parent = m.Value
 	} else {
-		return errors.New("invalid attribute value")
+		i.Parent = parentOf(i.Key)
+	}
+	// EncryptedDEK, Nonce, and KMSKeyID are only present on
+	// envelope-encrypted items; loadValue and Storage.decrypt fail
+	// closed if they're inconsistent with each other.
+	if m, ok := item["EncryptedDEK"].(*types.AttributeValueMemberB); ok {
+		i.EncryptedDEK = m.Value
+	}
+	if m, ok := item["Nonce"].(*types.AttributeValueMemberB); ok {
+		i.Nonce = m.Value
+	}
+	if m, ok := item["KMSKeyID"].(*types.AttributeValueMemberS); ok {
+		i.KMSKeyID = m.Value
+	}
+	// ChunkCount, Hash, S3Key, and S3ETag are only present on
+	// manifest items for values too large for a single DynamoDB
+	// item; see chunking.go.
+	if m, ok := item["ChunkCount"].(*types.AttributeValueMemberN); ok {
+		n, err := strconv.Atoi(m.Value)
+		if err != nil {
+			return err
+		}
+		i.ChunkCount = n
+	}
+	if m, ok := item["Hash"].(*types.AttributeValueMemberB); ok {
+		i.Hash = m.Value
+	}
+	if m, ok := item["S3Key"].(*types.AttributeValueMemberS); ok {
+		i.S3Key = m.Value
+	}
+	if m, ok := item["S3ETag"].(*types.AttributeValueMemberS); ok {
+		i.S3ETag = m.Value
 	}
 	return nil
 }
@@ -0,0 +1,70 @@
+package ddbcertstorage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestItemRoundTrip(t *testing.T) {
+	want := &Item{
+		Key:          "example.com/cert",
+		Contents:     []byte("cert bytes"),
+		Modified:     time.Now().Truncate(time.Second).UTC(),
+		Size:         10,
+		IsTerminal:   true,
+		EncryptedDEK: []byte("dek"),
+		Nonce:        []byte("nonce"),
+		KMSKeyID:     "key-id",
+		ChunkCount:   3,
+		Hash:         []byte("hash"),
+		S3Key:        "s3/key",
+		S3ETag:       "etag",
+	}
+	var got Item
+	if err := got.Load(want.Item()); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got.Key != want.Key ||
+		!bytes.Equal(got.Contents, want.Contents) ||
+		!got.Modified.Equal(want.Modified) ||
+		got.Size != want.Size ||
+		got.IsTerminal != want.IsTerminal ||
+		!bytes.Equal(got.EncryptedDEK, want.EncryptedDEK) ||
+		!bytes.Equal(got.Nonce, want.Nonce) ||
+		got.KMSKeyID != want.KMSKeyID ||
+		got.ChunkCount != want.ChunkCount ||
+		!bytes.Equal(got.Hash, want.Hash) ||
+		got.S3Key != want.S3Key ||
+		got.S3ETag != want.S3ETag {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", got, want)
+	}
+	if got.Parent != "example.com" {
+		t.Fatalf("Parent = %q, want %q", got.Parent, "example.com")
+	}
+}
+
+func TestItemLoadParentFallback(t *testing.T) {
+	item := (&Item{Key: "a/b/cert", Modified: time.Now()}).Item()
+	delete(item, "Parent")
+	var loaded Item
+	if err := loaded.Load(item); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if loaded.Parent != "a/b" {
+		t.Fatalf("Parent fallback = %q, want %q", loaded.Parent, "a/b")
+	}
+}
+
+func TestParentOf(t *testing.T) {
+	cases := map[string]string{
+		"a/b/c": "a/b",
+		"a":     "",
+		"":      "",
+	}
+	for key, want := range cases {
+		if got := parentOf(key); got != want {
+			t.Errorf("parentOf(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
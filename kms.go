@@ -0,0 +1,115 @@
+package ddbcertstorage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// WithKMSKey enables client-side envelope encryption of every
+// item's Contents using AWS KMS key keyID: Store generates a data
+// key with GenerateDataKey and seals Contents with it under
+// AES-256-GCM, and Load transparently unseals it. Items written
+// before this option was set are read back as plaintext; items
+// written under one KMS key ID are always decrypted with that
+// same key ID, recorded alongside the ciphertext.
+func WithKMSKey(keyID string) Option {
+	return func(s *Storage) {
+		s.kmsKeyID = keyID
+	}
+}
+
+// WithDEKCacheTTL overrides how long an unwrapped data encryption
+// key is cached after a KMS Decrypt call, avoiding a KMS round
+// trip on every Load of a key encrypted under WithKMSKey. Only
+// meaningful alongside WithKMSKey. The default is 5 minutes.
+func WithDEKCacheTTL(ttl time.Duration) Option {
+	return func(s *Storage) {
+		s.dekCacheTTL = ttl
+	}
+}
+
+// encrypt seals plaintext under a freshly generated KMS data key,
+// returning the ciphertext, the KMS-wrapped data key, and the GCM
+// nonce to store alongside it.
+func (s *Storage) encrypt(ctx context.Context, plaintext []byte) (ciphertext, encryptedDEK, nonce []byte, err error) {
+	out, err := s.kms.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   &s.kmsKeyID,
+		KeySpec: types.DataKeySpecAes256,
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	gcm, err := newGCM(out.Plaintext)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, nil, err
+	}
+	ciphertext = gcm.Seal(nil, nonce, plaintext, nil)
+	return ciphertext, out.CiphertextBlob, nonce, nil
+}
+
+// decrypt unseals an item's Contents, failing closed if the
+// item's KMS metadata is missing or inconsistent rather than
+// ever handing the caller raw ciphertext.
+func (s *Storage) decrypt(ctx context.Context, item *Item) ([]byte, error) {
+	if len(item.EncryptedDEK) == 0 || len(item.Nonce) == 0 {
+		return nil, fmt.Errorf("ddbcertstorage: item %q is marked encrypted with KMS key %q but is missing DEK/nonce metadata", item.Key, item.KMSKeyID)
+	}
+	if s.kms == nil {
+		return nil, fmt.Errorf("ddbcertstorage: item %q is KMS-encrypted but Storage was not configured with WithKMSKey", item.Key)
+	}
+	dek, err := s.dataKey(ctx, item.KMSKeyID, item.EncryptedDEK)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, item.Nonce, item.Contents, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ddbcertstorage: decrypting item %q: %w", item.Key, err)
+	}
+	return plaintext, nil
+}
+
+// dataKey returns the plaintext data key that encryptedDEK
+// unwraps to, serving it from the DEK cache when possible.
+func (s *Storage) dataKey(ctx context.Context, keyID string, encryptedDEK []byte) ([]byte, error) {
+	cacheKey := base64.StdEncoding.EncodeToString(encryptedDEK)
+	if s.dekCache != nil {
+		if dek, ok := s.dekCache.Get(cacheKey); ok {
+			return dek, nil
+		}
+	}
+	out, err := s.kms.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob: encryptedDEK,
+		KeyId:          &keyID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.dekCache != nil {
+		s.dekCache.Add(cacheKey, out.Plaintext)
+	}
+	return out.Plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
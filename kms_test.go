@@ -0,0 +1,53 @@
+package ddbcertstorage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestGCMSealOpenRoundTrip(t *testing.T) {
+	key := make([]byte, 32) // AES-256
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatalf("newGCM: %v", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatalf("generating nonce: %v", err)
+	}
+	plaintext := []byte("this is a certificate's private key, allegedly")
+	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
+	if bytes.Equal(ciphertext, plaintext) {
+		t.Fatal("Seal returned the plaintext unchanged")
+	}
+	got, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("Open = %q, want %q", got, plaintext)
+	}
+}
+
+func TestGCMOpenRejectsWrongKey(t *testing.T) {
+	key1 := make([]byte, 32)
+	key2 := make([]byte, 32)
+	key2[0] = key1[0] + 1 // guarantee the keys differ
+	gcm1, err := newGCM(key1)
+	if err != nil {
+		t.Fatalf("newGCM(key1): %v", err)
+	}
+	gcm2, err := newGCM(key2)
+	if err != nil {
+		t.Fatalf("newGCM(key2): %v", err)
+	}
+	nonce := make([]byte, gcm1.NonceSize())
+	ciphertext := gcm1.Seal(nil, nonce, []byte("secret"), nil)
+	if _, err := gcm2.Open(nil, nonce, ciphertext, nil); err == nil {
+		t.Fatal("Open succeeded with the wrong key")
+	}
+}
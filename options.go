@@ -0,0 +1,6 @@
+package ddbcertstorage
+
+// Option configures a Storage at construction time. Options are
+// applied in order after the DynamoDB client (or DAX client) has
+// been set up, so an Option may safely assume s.ddb is non-nil.
+type Option func(s *Storage)
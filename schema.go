@@ -0,0 +1,304 @@
+package ddbcertstorage
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// parentIndexName is the Global Secondary Index (hash key
+// Parent, range key Key) that List and Delete query against
+// instead of scanning the full table. See MigrateSchema.
+const parentIndexName = "ParentIndex"
+
+// WithLegacyScan makes List and recursive Delete fall back to a
+// full-table Scan with a begins_with filter, for tables that
+// have not yet run MigrateSchema and so have neither a Parent
+// attribute on their items nor a ParentIndex GSI. New tables
+// should leave this disabled (the default).
+func WithLegacyScan(enabled bool) Option {
+	return func(s *Storage) {
+		s.useLegacyScan = enabled
+	}
+}
+
+// queryChildren returns every item whose Parent attribute is
+// exactly parent, i.e. the direct children of parent, via the
+// ParentIndex GSI.
+func (s *Storage) queryChildren(ctx context.Context, parent string) ([]Item, error) {
+	var items []Item
+	var startKey map[string]types.AttributeValue
+	for {
+		output, err := s.ddb.Query(ctx, &dynamodb.QueryInput{
+			TableName:                 &s.table,
+			IndexName:                 aws.String(parentIndexName),
+			KeyConditionExpression:    aws.String("Parent = :p"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":p": &types.AttributeValueMemberS{Value: parent}},
+			ExclusiveStartKey:         startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range output.Items {
+			var item Item
+			if err := item.Load(i); err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		if output.LastEvaluatedKey == nil {
+			return items, nil
+		}
+		startKey = output.LastEvaluatedKey
+	}
+}
+
+// listIndexed implements List by querying ParentIndex instead of
+// scanning the table.
+func (s *Storage) listIndexed(ctx context.Context, path string, recursive bool) ([]string, error) {
+	children, err := s.queryChildren(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(children) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	keys := make([]string, 0, len(children))
+	queue := make([]Item, 0, len(children))
+	for _, child := range children {
+		keys = append(keys, child.Key)
+		if recursive && !child.IsTerminal {
+			queue = append(queue, child)
+		}
+	}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		grandchildren, err := s.queryChildren(ctx, dir.Key)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range grandchildren {
+			keys = append(keys, child.Key)
+			if !child.IsTerminal {
+				queue = append(queue, child)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// listScan is the pre-GSI List implementation kept for tables
+// running with WithLegacyScan.
+func (s *Storage) listScan(ctx context.Context, path string, recursive bool) ([]string, error) {
+	output, err := s.ddb.Scan(ctx, &dynamodb.ScanInput{
+		TableName:                 &s.table,
+		ExpressionAttributeNames:  map[string]string{"#key": "Key"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":key": &types.AttributeValueMemberS{Value: path + "/"}},
+		FilterExpression:          aws.String("begins_with(#key, :key)"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Items) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	keys := make([]string, 0, len(output.Items))
+	for _, i := range output.Items {
+		var item Item
+		if err := item.Load(i); err != nil {
+			return nil, err
+		}
+		if !recursive {
+			// these two paths go through foo:
+			// foo/cert/key
+			// foo/cert/chain
+			//
+			// So List(prefix: "foo", recursive: false) would return:
+			// foo/cert
+			name := strings.TrimPrefix(item.Key, path+"/")
+			if strings.Contains(name, "/") {
+				continue
+			}
+		}
+		keys = append(keys, item.Key)
+	}
+	return keys, nil
+}
+
+// deleteChildrenIndexed deletes every descendant of key by BFS
+// walking ParentIndex, mirroring listIndexed's traversal, batching
+// the deletes via BatchWriteItem.
+func (s *Storage) deleteChildrenIndexed(ctx context.Context, key string) error {
+	var requests []types.WriteRequest
+	queue := []string{key}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		children, err := s.queryChildren(ctx, parent)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			requests = append(requests, deleteRequest(child.Key))
+			if child.IsTerminal {
+				s.deleteOverflow(ctx, &child)
+			} else {
+				queue = append(queue, child.Key)
+			}
+		}
+	}
+	if len(requests) == 0 {
+		return fs.ErrNotExist
+	}
+	return batchWriteItems(ctx, s.ddb, s.table, requests)
+}
+
+// deleteChildrenScan is the pre-GSI recursive Delete
+// implementation kept for tables running with WithLegacyScan.
+func (s *Storage) deleteChildrenScan(ctx context.Context, key string) error {
+	output, err := s.ddb.Scan(ctx, &dynamodb.ScanInput{
+		TableName:                 &s.table,
+		ExpressionAttributeNames:  map[string]string{"#key": "Key"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":key": &types.AttributeValueMemberS{Value: key + "/"}},
+		FilterExpression:          aws.String("begins_with(#key, :key)"),
+	})
+	if err != nil {
+		return err
+	}
+	if len(output.Items) == 0 {
+		return fs.ErrNotExist
+	}
+	requests := make([]types.WriteRequest, 0, len(output.Items))
+	for _, raw := range output.Items {
+		var child Item
+		if err := child.Load(raw); err != nil {
+			return err
+		}
+		requests = append(requests, deleteRequest(child.Key))
+		if child.IsTerminal {
+			s.deleteOverflow(ctx, &child)
+		}
+	}
+	return batchWriteItems(ctx, s.ddb, s.table, requests)
+}
+
+// deleteRequest builds the WriteRequest BatchWriteItem expects to
+// delete the row with the given Key.
+func deleteRequest(key string) types.WriteRequest {
+	return types.WriteRequest{
+		DeleteRequest: &types.DeleteRequest{
+			Key: map[string]types.AttributeValue{
+				"Key": &types.AttributeValueMemberS{Value: key},
+			},
+		},
+	}
+}
+
+// schemaMigrator is the extra DynamoDB API surface MigrateSchema
+// needs beyond DynamoAPI. It is satisfied by *dynamodb.Client but
+// generally not by a DAX client, since DAX does not proxy table
+// management calls.
+type schemaMigrator interface {
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	UpdateTable(ctx context.Context, params *dynamodb.UpdateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTableOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// MigrateSchema prepares an existing table for GSI-backed List
+// and Delete: it creates the ParentIndex Global Secondary Index
+// if it does not already exist, then scans the table and
+// backfills the Parent attribute on every item that predates it.
+// It is idempotent and safe to run against a live table; once it
+// completes, WithLegacyScan can be left disabled (the default).
+func (s *Storage) MigrateSchema(ctx context.Context) error {
+	ddb, ok := s.ddb.(schemaMigrator)
+	if !ok {
+		return fmt.Errorf("ddbcertstorage: DynamoAPI implementation does not support schema migration")
+	}
+	if err := s.ensureParentIndex(ctx, ddb); err != nil {
+		return err
+	}
+	return s.backfillParent(ctx, ddb)
+}
+
+func (s *Storage) ensureParentIndex(ctx context.Context, ddb schemaMigrator) error {
+	described, err := ddb.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: &s.table})
+	if err != nil {
+		return err
+	}
+	for _, gsi := range described.Table.GlobalSecondaryIndexes {
+		if aws.ToString(gsi.IndexName) == parentIndexName {
+			return nil
+		}
+	}
+	_, err = ddb.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+		TableName: &s.table,
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("Parent"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("Key"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{
+			{
+				Create: &types.CreateGlobalSecondaryIndexAction{
+					IndexName: aws.String(parentIndexName),
+					KeySchema: []types.KeySchemaElement{
+						{AttributeName: aws.String("Parent"), KeyType: types.KeyTypeHash},
+						{AttributeName: aws.String("Key"), KeyType: types.KeyTypeRange},
+					},
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				},
+			},
+		},
+	})
+	return err
+}
+
+// backfillParent scans the table and writes the Parent attribute
+// on every filesystem item that doesn't already have one. Lock
+// rows are skipped: they aren't part of the filesystem tree and
+// have no meaningful Parent.
+func (s *Storage) backfillParent(ctx context.Context, ddb schemaMigrator) error {
+	var startKey map[string]types.AttributeValue
+	for {
+		output, err := s.ddb.Scan(ctx, &dynamodb.ScanInput{
+			TableName:         &s.table,
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return err
+		}
+		for _, raw := range output.Items {
+			if _, ok := raw["Parent"]; ok {
+				continue
+			}
+			keyAttr, ok := raw["Key"].(*types.AttributeValueMemberS)
+			if !ok {
+				return fmt.Errorf("ddbcertstorage: item missing Key attribute during migration")
+			}
+			if strings.HasPrefix(keyAttr.Value, lockKeyPrefix) {
+				continue
+			}
+			if _, err := ddb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName: &s.table,
+				Key: map[string]types.AttributeValue{
+					"Key": keyAttr,
+				},
+				UpdateExpression:          aws.String("SET #parent = :parent"),
+				ExpressionAttributeNames:  map[string]string{"#parent": "Parent"},
+				ExpressionAttributeValues: map[string]types.AttributeValue{":parent": &types.AttributeValueMemberS{Value: parentOf(keyAttr.Value)}},
+			}); err != nil {
+				return err
+			}
+		}
+		if output.LastEvaluatedKey == nil {
+			return nil
+		}
+		startKey = output.LastEvaluatedKey
+	}
+}
@@ -0,0 +1,243 @@
+package ddbcertstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// lockKeyPrefix marks lock rows in the table so that schema
+// migration and other item-oriented scans can tell them apart
+// from filesystem rows.
+const lockKeyPrefix = "LOCK-"
+
+// heldLock tracks a lock this process currently holds: the
+// fencing token it acquired the lock with, and the channel used
+// to stop that lock's heartbeat goroutine.
+type heldLock struct {
+	token string
+	stop  chan struct{}
+}
+
+// WithLockTTL overrides how long a lock may be held before its
+// DynamoDB TTL attribute allows it to be reaped. It also bounds
+// how long a caller blocked in Lock waits before taking over a
+// lock abandoned by a process that stopped heartbeating. The
+// default is one minute.
+func WithLockTTL(ttl time.Duration) Option {
+	return func(s *Storage) {
+		s.lockTTL = ttl
+	}
+}
+
+// WithHeartbeatInterval overrides how often a held lock's
+// Locked/Expires attributes are refreshed in the background.
+// It should be well under LockTTL. The default is 20 seconds.
+func WithHeartbeatInterval(interval time.Duration) Option {
+	return func(s *Storage) {
+		s.heartbeatInterval = interval
+	}
+}
+
+// WithTTLAttribute controls whether EnsureTable configures
+// DynamoDB's native TTL to reap abandoned lock items. Lock always
+// writes the Expires attribute and uses it to take over a stale
+// lock itself, regardless of this setting. Disable it if the
+// table's TTL is managed elsewhere (or not wanted at all); expired
+// locks are still taken over by Lock, just never physically
+// deleted by DynamoDB on its own.
+func WithTTLAttribute(enabled bool) Option {
+	return func(s *Storage) {
+		s.enableTTLAttribute = enabled
+	}
+}
+
+// EnsureTable configures the table's TTL specification so that
+// DynamoDB reaps lock items whose Expires attribute has passed.
+// It is safe to call more than once and is typically called once
+// at startup or as part of provisioning.
+func (s *Storage) EnsureTable(ctx context.Context) error {
+	ddb, ok := s.ddb.(interface {
+		UpdateTimeToLive(ctx context.Context, params *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error)
+	})
+	if !ok {
+		return errors.New("ddbcertstorage: DynamoAPI implementation does not support UpdateTimeToLive")
+	}
+	if !s.enableTTLAttribute {
+		return nil
+	}
+	_, err := ddb.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: &s.table,
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String("Expires"),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	return err
+}
+
+// Lock acquires the lock for name, blocking until the lock
+// can be obtained or an error is returned. Only one lock
+// for the given name can exist at a time. A call to Lock for
+// a name which already exists blocks until the named lock
+// is released or becomes stale.
+//
+// Lock writes an Expires attribute (Unix seconds, LockTTL from
+// now) that DynamoDB TTL reaps the lock item with, and stamps
+// the item with a fencing token. While held, a background
+// goroutine refreshes Locked/Expires every HeartbeatInterval
+// using a conditional update keyed on that token, so a process
+// that stops heartbeating (crash, GC pause, network partition)
+// loses the lock once Expires passes, even if its own Unlock
+// never runs.
+//
+// If the named lock represents an idempotent operation, callers
+// should always check to make sure the work still needs to be
+// completed after acquiring the lock. You never know if another
+// process already completed the task while you were waiting to
+// acquire it.
+//
+// Implementations should honor context cancellation.
+func (s *Storage) Lock(ctx context.Context, name string) error {
+	key := fmt.Sprintf("%s%s", lockKeyPrefix, name)
+	token := uuid.NewString()
+	for {
+		now := time.Now()
+		expires := now.Add(s.lockTTL)
+		item := map[string]types.AttributeValue{
+			"Key":     &types.AttributeValueMemberS{Value: key},
+			"Locked":  &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+			"Token":   &types.AttributeValueMemberS{Value: token},
+			"Expires": &types.AttributeValueMemberN{Value: strconv.FormatInt(expires.Unix(), 10)},
+		}
+		_, err := s.ddb.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:                &s.table,
+			Item:                     item,
+			ExpressionAttributeNames: map[string]string{"#key": "Key", "#expires": "Expires"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(#key) OR #expires < :now"),
+		})
+		if err == nil {
+			s.startHeartbeat(name, key, token)
+			return nil
+		}
+		var condCheckErr *types.ConditionalCheckFailedException
+		if !errors.As(err, &condCheckErr) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Unlock releases named lock. This method must ONLY be called
+// after a successful call to Lock, and only after the critical
+// section is finished, even if it errored or timed out. Unlock
+// cleans up any resources allocated during Lock. Unlock should
+// only return an error if the lock was unable to be released.
+func (s *Storage) Unlock(ctx context.Context, name string) error {
+	token, ok := s.stopHeartbeat(name)
+	if !ok {
+		return fmt.Errorf("ddbcertstorage: Unlock called for %q without a matching Lock", name)
+	}
+	return s.deleteLock(ctx, name, token)
+}
+
+// deleteLock deletes the lock row for name, but only if its
+// Token still matches the fencing token this process acquired
+// it with. This prevents a slow or stuck unlocker from ever
+// clobbering a lock that expired and was re-acquired by another
+// process in the meantime.
+func (s *Storage) deleteLock(ctx context.Context, name, token string) error {
+	_, err := s.ddb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: &s.table,
+		Key: map[string]types.AttributeValue{
+			"Key": &types.AttributeValueMemberS{Value: fmt.Sprintf("%s%s", lockKeyPrefix, name)},
+		},
+		ExpressionAttributeNames:  map[string]string{"#token": "Token"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":token": &types.AttributeValueMemberS{Value: token}},
+		ConditionExpression:       aws.String("#token = :token"),
+	})
+	var condCheckErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condCheckErr) {
+		// Someone else's fencing token now owns the row; our lock
+		// already expired and was taken over, so there is nothing
+		// left for us to clean up.
+		return nil
+	}
+	return err
+}
+
+// startHeartbeat records that name is held under token and
+// launches the goroutine that keeps it alive until stopHeartbeat
+// is called.
+func (s *Storage) startHeartbeat(name, key, token string) {
+	held := &heldLock{token: token, stop: make(chan struct{})}
+	s.locksMu.Lock()
+	s.locks[name] = held
+	s.locksMu.Unlock()
+	go s.heartbeat(key, held)
+}
+
+// stopHeartbeat stops the heartbeat goroutine for name, if any,
+// and returns the fencing token it was started with.
+func (s *Storage) stopHeartbeat(name string) (token string, ok bool) {
+	s.locksMu.Lock()
+	held, ok := s.locks[name]
+	delete(s.locks, name)
+	s.locksMu.Unlock()
+	if !ok {
+		return "", false
+	}
+	close(held.stop)
+	return held.token, true
+}
+
+func (s *Storage) heartbeat(key string, held *heldLock) {
+	ticker := time.NewTicker(s.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-held.stop:
+			return
+		case <-ticker.C:
+			s.renewLock(key, held.token)
+		}
+	}
+}
+
+// renewLock refreshes Locked/Expires for key, conditioned on
+// Token still matching. Renewal runs on a background context
+// since the caller's ctx may already be done by the time a tick
+// fires; it logs nothing on failure because losing a race to
+// renew simply means the lock was already taken over, which Lock
+// and Unlock both tolerate.
+func (s *Storage) renewLock(key, token string) {
+	now := time.Now()
+	expires := now.Add(s.lockTTL)
+	update := map[string]types.AttributeValue{
+		"Key":     &types.AttributeValueMemberS{Value: key},
+		"Locked":  &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+		"Token":   &types.AttributeValueMemberS{Value: token},
+		"Expires": &types.AttributeValueMemberN{Value: strconv.FormatInt(expires.Unix(), 10)},
+	}
+	_, _ = s.ddb.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName:                 &s.table,
+		Item:                      update,
+		ExpressionAttributeNames:  map[string]string{"#token": "Token"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":token": &types.AttributeValueMemberS{Value: token}},
+		ConditionExpression:       aws.String("#token = :token"),
+	})
+}
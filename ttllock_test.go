@@ -0,0 +1,105 @@
+package ddbcertstorage
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func newTestStorage(ddb DynamoAPI, opts ...Option) *Storage {
+	return newStorage("test-table", ddb, aws.Config{}, opts...)
+}
+
+func TestLockAlwaysWritesExpiresEvenWithTTLAttributeDisabled(t *testing.T) {
+	ddb := newFakeDDB()
+	s := newTestStorage(ddb, WithTTLAttribute(false))
+	ctx := context.Background()
+	if err := s.Lock(ctx, "cert-a"); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	defer s.Unlock(ctx, "cert-a")
+	item, ok := ddb.items[lockKeyPrefix+"cert-a"]
+	if !ok {
+		t.Fatalf("lock row %q not found", lockKeyPrefix+"cert-a")
+	}
+	if _, ok := item["Expires"]; !ok {
+		t.Fatal("Expires attribute missing even though it must always be written for Lock to be able to take over a stale lock")
+	}
+}
+
+func TestLockTakesOverStaleLock(t *testing.T) {
+	ddb := newFakeDDB()
+	s := newTestStorage(ddb)
+	ctx := context.Background()
+
+	past := time.Now().Add(-time.Minute)
+	ddb.items[lockKeyPrefix+"cert-b"] = map[string]types.AttributeValue{
+		"Key":     &types.AttributeValueMemberS{Value: lockKeyPrefix + "cert-b"},
+		"Locked":  &types.AttributeValueMemberS{Value: past.Format(time.RFC3339)},
+		"Token":   &types.AttributeValueMemberS{Value: "stale-token"},
+		"Expires": &types.AttributeValueMemberN{Value: strconv.FormatInt(past.Unix(), 10)},
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Lock(ctx, "cert-b") }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Lock did not take over stale lock: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Lock blocked instead of taking over a stale (expired) lock")
+	}
+	defer s.Unlock(ctx, "cert-b")
+
+	item := ddb.items[lockKeyPrefix+"cert-b"]
+	if item["Token"].(*types.AttributeValueMemberS).Value == "stale-token" {
+		t.Fatal("lock row still has the stale token; takeover did not stamp a new fencing token")
+	}
+}
+
+func TestLockBlocksOnLiveLock(t *testing.T) {
+	ddb := newFakeDDB()
+	s := newTestStorage(ddb)
+
+	future := time.Now().Add(time.Hour)
+	ddb.items[lockKeyPrefix+"cert-c"] = map[string]types.AttributeValue{
+		"Key":     &types.AttributeValueMemberS{Value: lockKeyPrefix + "cert-c"},
+		"Locked":  &types.AttributeValueMemberS{Value: time.Now().Format(time.RFC3339)},
+		"Token":   &types.AttributeValueMemberS{Value: "live-token"},
+		"Expires": &types.AttributeValueMemberN{Value: strconv.FormatInt(future.Unix(), 10)},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := s.Lock(ctx, "cert-c")
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Lock on a live (unexpired) lock = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestUnlockTakeoverIsTolerated(t *testing.T) {
+	ddb := newFakeDDB()
+	s := newTestStorage(ddb)
+	ctx := context.Background()
+
+	if err := s.Lock(ctx, "cert-d"); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	ownToken, _ := s.stopHeartbeat("cert-d")
+
+	// Simulate another process taking over the lock after it expired.
+	ddb.items[lockKeyPrefix+"cert-d"]["Token"] = &types.AttributeValueMemberS{Value: "someone-elses-token"}
+
+	if err := s.deleteLock(ctx, "cert-d", ownToken); err != nil {
+		t.Fatalf("deleteLock should tolerate a fencing token that was already taken over, got: %v", err)
+	}
+	if _, ok := ddb.items[lockKeyPrefix+"cert-d"]; !ok {
+		t.Fatal("deleteLock must not delete a row whose token no longer matches")
+	}
+}